@@ -23,12 +23,15 @@ import (
 	"net/url"
 	"sort"
 	"strings"
+	"time"
 
 	securityapi "istio.io/api/security/v1alpha1"
 	"istio.io/istio/pkg/log"
 	"istio.io/istio/pkg/security"
 	pkiutil "istio.io/istio/security/pkg/pki/util"
 
+	"github.com/cert-manager/istio-csr/pkg/server/authz"
+	"github.com/cert-manager/istio-csr/pkg/server/internal/algorithm"
 	"github.com/cert-manager/istio-csr/pkg/server/internal/extensions"
 )
 
@@ -37,10 +40,15 @@ import (
 func (s *Server) authRequest(ctx context.Context, icr *securityapi.IstioCertificateRequest) (string, bool) {
 	var caller *security.Caller
 	var errs []error
+	var allowPrefixMatch bool
 	found := false
 	for _, authenticator := range s.authenticators {
 		var err error
-		caller, err = authenticator.Authenticate(security.AuthContext{GrpcContext: ctx})
+		if pa, ok := authenticator.(prefixAuthenticator); ok {
+			caller, allowPrefixMatch, err = pa.AuthenticateWithPrefixMatch(security.AuthContext{GrpcContext: ctx})
+		} else {
+			caller, err = authenticator.Authenticate(security.AuthContext{GrpcContext: ctx})
+		}
 		if err == nil {
 			found = true
 			break
@@ -60,6 +68,7 @@ func (s *Server) authRequest(ctx context.Context, icr *securityapi.IstioCertific
 	}
 
 	var identities string
+	var identityList []string
 
 	crMetadata := icr.GetMetadata().GetFields()
 	impersonatedIdentity := crMetadata[security.ImpersonatedIdentity].GetStringValue()
@@ -74,8 +83,10 @@ func (s *Server) authRequest(ctx context.Context, icr *securityapi.IstioCertific
 			return identities, false
 		}
 		identities = impersonatedIdentity
+		identityList = []string{impersonatedIdentity}
 	} else {
 		identities = strings.Join(caller.Identities, ",")
+		identityList = caller.Identities
 	}
 
 	// return concatenated list of verified ids
@@ -92,6 +103,13 @@ func (s *Server) authRequest(ctx context.Context, icr *securityapi.IstioCertific
 		return identities, false
 	}
 
+	if s.algorithmRegistry != nil {
+		if err := s.algorithmRegistry.Validate(identityList, csr); err != nil {
+			log.Error(err, "CSR failed algorithm policy", "reason", "algorithm_forbidden")
+			return identities, false
+		}
+	}
+
 	// if the csr contains any other options set, error
 	if len(csr.IPAddresses) > 0 || len(csr.EmailAddresses) > 0 {
 		log.Error(errors.New("forbidden extensions"), "",
@@ -101,29 +119,66 @@ func (s *Server) authRequest(ctx context.Context, icr *securityapi.IstioCertific
 		return identities, false
 	}
 
-	// ensure csr extensions are valid
-	if err := extensions.ValidateCSRExtentions(csr); err != nil {
+	// ensure csr extensions and SANs are valid
+	sanPolicy := s.sanPolicy
+	if sanPolicy == nil {
+		defaultPolicy := extensions.DefaultSANPolicy()
+		sanPolicy = &defaultPolicy
+	}
+	if err := extensions.ValidateCSRExtentions(csr, identityList, *sanPolicy); err != nil {
 		log.Error(err, "forbidden extensions")
 		return identities, false
 	}
 
 	if impersonatedIdentity == "" {
-		if !identitiesMatch(caller.Identities, csr.URIs) {
+		if !identitiesMatch(caller.Identities, csr.URIs, allowPrefixMatch) {
 			log.Error(fmt.Errorf("%v != %v", caller.Identities, csr.URIs), "failed to match URIs with identities")
 			return identities, false
 		}
-	} else if !identitiesMatch([]string{impersonatedIdentity}, csr.URIs) {
+	} else if !identitiesMatch([]string{impersonatedIdentity}, csr.URIs, false) {
 		log.Error(fmt.Errorf("%v != %v", impersonatedIdentity, csr.URIs), "failed to match URIs with impersonated identities")
 		return identities, false
 	}
 
+	if s.authzEngine != nil {
+		uris := make([]string, len(csr.URIs))
+		for i, u := range csr.URIs {
+			uris[i] = u.String()
+		}
+
+		if err := s.authzEngine.Evaluate(authz.Request{
+			Caller:            caller,
+			Identity:          identities,
+			Identities:        identityList,
+			URIs:              uris,
+			DNSNames:          csr.DNSNames,
+			RequestedKeyUsage: authz.ExtractRequestedKeyUsage(csr.Extensions),
+			RequestedDuration: time.Duration(icr.GetValidityDuration()) * time.Second,
+		}); err != nil {
+			log.Error(err, "request denied by authz policy")
+			return identities, false
+		}
+	}
+
 	// return positive authn of given csr
 	return identities, true
 }
 
+// prefixAuthenticator is implemented by authenticators whose caller
+// identity is not itself a SPIFFE URI (e.g. an OIDC authenticator deriving
+// identity from token claims), but which may opt in - per issuer, and so
+// only for the specific authentication that just succeeded - to authorizing
+// a CSR URI SAN that shares the caller identity as a prefix, rather than
+// requiring an exact match.
+type prefixAuthenticator interface {
+	AuthenticateWithPrefixMatch(security.AuthContext) (*security.Caller, bool, error)
+}
+
 // identitiesMatch will ensure that two list of identities given from the
-// request context, and those parsed from the CSR, match
-func identitiesMatch(a []string, b []*url.URL) bool {
+// request context, and those parsed from the CSR, match. If prefixMatch is
+// true, each identity in a only needs to prefix-match its corresponding
+// identity in b, rather than match it exactly.
+func identitiesMatch(a []string, b []*url.URL, prefixMatch bool) bool {
 	if len(a) != len(b) {
 		return false
 	}
@@ -140,6 +195,12 @@ func identitiesMatch(a []string, b []*url.URL) bool {
 	})
 
 	for i, v := range aa {
+		if prefixMatch {
+			if !hasPrefixOnSegmentBoundary(bb[i].String(), v) {
+				return false
+			}
+			continue
+		}
 		if bb[i].String() != v {
 			return false
 		}
@@ -147,3 +208,15 @@ func identitiesMatch(a []string, b []*url.URL) bool {
 
 	return true
 }
+
+// hasPrefixOnSegmentBoundary reports whether s starts with prefix and, if
+// s is longer than prefix, the next byte is a "/". This stops a caller
+// identity of "spiffe://td/ns/foo" from prefix-matching a CSR URI of
+// "spiffe://td/ns/foobar/sa/admin", which a plain strings.HasPrefix would
+// allow.
+func hasPrefixOnSegmentBoundary(s, prefix string) bool {
+	if !strings.HasPrefix(s, prefix) {
+		return false
+	}
+	return len(s) == len(prefix) || s[len(prefix)] == '/'
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2021 The cert-manager Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net/url"
+	"testing"
+)
+
+func mustParseURL(t *testing.T, raw string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatalf("failed to parse %q: %v", raw, err)
+	}
+	return u
+}
+
+func TestIdentitiesMatch_PrefixMode(t *testing.T) {
+	tests := map[string]struct {
+		identities []string
+		uris       []string
+		want       bool
+	}{
+		"exact match is always allowed": {
+			identities: []string{"spiffe://td/ns/foo/sa/a"},
+			uris:       []string{"spiffe://td/ns/foo/sa/a"},
+			want:       true,
+		},
+		"child path segment is allowed": {
+			identities: []string{"spiffe://td/ns/foo"},
+			uris:       []string{"spiffe://td/ns/foo/sa/admin"},
+			want:       true,
+		},
+		"sibling path that merely shares the string prefix is rejected": {
+			identities: []string{"spiffe://td/ns/foo"},
+			uris:       []string{"spiffe://td/ns/foobar/sa/admin"},
+			want:       false,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			uris := make([]*url.URL, len(tc.uris))
+			for i, u := range tc.uris {
+				uris[i] = mustParseURL(t, u)
+			}
+
+			if got := identitiesMatch(tc.identities, uris, true); got != tc.want {
+				t.Errorf("identitiesMatch(%v, %v, true) = %v, want %v", tc.identities, tc.uris, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestIdentitiesMatch_ExactMode(t *testing.T) {
+	identities := []string{"spiffe://td/ns/foo/sa/a"}
+	uris := []*url.URL{mustParseURL(t, "spiffe://td/ns/foo/sa/ab")}
+
+	if identitiesMatch(identities, uris, false) {
+		t.Errorf("expected exact mode to reject a non-identical URI")
+	}
+}
@@ -0,0 +1,225 @@
+/*
+Copyright 2021 The cert-manager Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package oidc implements a security.Authenticator which validates a bearer
+// JWT issued by a federated OIDC provider, rather than a Kubernetes
+// ServiceAccount token. This allows workloads that authenticate via an
+// external identity provider (e.g. GitHub Actions, Vault, other IdPs) to
+// request certificates without the cluster's own Kubernetes API server.
+package oidc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+
+	goidc "github.com/coreos/go-oidc/v3/oidc"
+	"google.golang.org/grpc/metadata"
+
+	"istio.io/istio/pkg/security"
+)
+
+const (
+	// bearerPrefix is the metadata value prefix carrying the JWT.
+	bearerPrefix = "Bearer "
+
+	// AuthenticatorType is returned by AuthenticatorType and included in
+	// logs to distinguish OIDC-authenticated requests from Kubernetes SA
+	// authenticated ones.
+	AuthenticatorType = "OIDCTokenAuthenticator"
+
+	// defaultIdentityClaimTemplate extracts the standard "sub" claim when
+	// no template is configured.
+	defaultIdentityClaimTemplate = "{{ .Sub }}"
+)
+
+// IssuerConfig configures a single trusted OIDC issuer.
+type IssuerConfig struct {
+	// IssuerURL is the OIDC discovery issuer, e.g. "https://token.actions.githubusercontent.com".
+	IssuerURL string
+	// JWKSURI overrides the JWKS endpoint discovered from IssuerURL. Optional.
+	JWKSURI string
+	// Audience is the expected "aud" claim of presented tokens.
+	Audience string
+	// IdentityClaimTemplate is a Go text/template rendered against the
+	// token claims to build the identity string returned in
+	// security.Caller.Identities. It defaults to the "sub" claim, but can
+	// build a SPIFFE URI from "iss"+"sub", or any mapped claim such as
+	// "spiffe_id", e.g. "spiffe://example.org/{{ .Sub }}".
+	IdentityClaimTemplate string
+	// AllowPrefixMatch opts this issuer into authorizing a CSR URI SAN
+	// that begins with the caller identity, ending on a "/" path segment
+	// boundary, rather than requiring an exact match. See identitiesMatch
+	// in pkg/server.
+	AllowPrefixMatch bool
+}
+
+// claims is the minimal set of standard claims consulted when rendering
+// IdentityClaimTemplate; additional claims are available via Extra.
+type claims struct {
+	Iss   string
+	Sub   string
+	Extra map[string]any
+}
+
+type trustedIssuer struct {
+	cfg      IssuerConfig
+	verifier *goidc.IDTokenVerifier
+	identity *template.Template
+}
+
+// Authenticator implements security.Authenticator against one or more
+// configured OIDC issuers.
+type Authenticator struct {
+	issuers []*trustedIssuer
+}
+
+// New builds an Authenticator from the given issuer configurations,
+// running OIDC discovery for each. The JWKS of each issuer is fetched and
+// cached by the underlying OIDC library when a token is first verified, and
+// the returned Authenticator rotates keys automatically as that library
+// refreshes its key sets.
+func New(ctx context.Context, configs []IssuerConfig) (*Authenticator, error) {
+	a := &Authenticator{}
+
+	for _, cfg := range configs {
+		provider, err := goidc.NewProvider(ctx, cfg.IssuerURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to discover oidc issuer %q: %w", cfg.IssuerURL, err)
+		}
+
+		verifierConfig := &goidc.Config{ClientID: cfg.Audience}
+		verifier := provider.Verifier(verifierConfig)
+		if cfg.JWKSURI != "" {
+			keySet := goidc.NewRemoteKeySet(ctx, cfg.JWKSURI)
+			verifier = goidc.NewVerifier(cfg.IssuerURL, keySet, verifierConfig)
+		}
+
+		tpl := cfg.IdentityClaimTemplate
+		if tpl == "" {
+			tpl = defaultIdentityClaimTemplate
+		}
+
+		identityTpl, err := template.New(cfg.IssuerURL).Parse(tpl)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse identity claim template for issuer %q: %w", cfg.IssuerURL, err)
+		}
+
+		a.issuers = append(a.issuers, &trustedIssuer{
+			cfg:      cfg,
+			verifier: verifier,
+			identity: identityTpl,
+		})
+	}
+
+	return a, nil
+}
+
+// AuthenticatorType returns a human readable name for this authenticator,
+// used in logs when multiple authenticators are configured.
+func (a *Authenticator) AuthenticatorType() string {
+	return AuthenticatorType
+}
+
+// Authenticate extracts a bearer JWT from the incoming gRPC metadata and
+// validates it against every configured issuer until one succeeds.
+func (a *Authenticator) Authenticate(authCtx security.AuthContext) (*security.Caller, error) {
+	caller, _, err := a.authenticate(authCtx)
+	return caller, err
+}
+
+// AuthenticateWithPrefixMatch implements pkg/server's prefixAuthenticator
+// interface. It behaves like Authenticate, but also reports whether the
+// specific issuer that verified this token has opted into prefix-scoped
+// SPIFFE URI authorization - so that a token from a strict issuer is never
+// granted prefix matching just because some other configured issuer allows
+// it.
+func (a *Authenticator) AuthenticateWithPrefixMatch(authCtx security.AuthContext) (*security.Caller, bool, error) {
+	return a.authenticate(authCtx)
+}
+
+func (a *Authenticator) authenticate(authCtx security.AuthContext) (*security.Caller, bool, error) {
+	token, err := bearerToken(authCtx.GrpcContext)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var errs []error
+	for _, iss := range a.issuers {
+		idToken, err := iss.verifier.Verify(authCtx.GrpcContext, token)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("issuer %q: %w", iss.cfg.IssuerURL, err))
+			continue
+		}
+
+		var extra map[string]any
+		if err := idToken.Claims(&extra); err != nil {
+			errs = append(errs, fmt.Errorf("issuer %q: failed to parse claims: %w", iss.cfg.IssuerURL, err))
+			continue
+		}
+
+		identity, err := iss.renderIdentity(idToken.Issuer, idToken.Subject, extra)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("issuer %q: %w", iss.cfg.IssuerURL, err))
+			continue
+		}
+
+		return &security.Caller{
+			Identities: []string{identity},
+		}, iss.cfg.AllowPrefixMatch, nil
+	}
+
+	return nil, false, fmt.Errorf("token not valid for any configured oidc issuer: %w", joinErrs(errs))
+}
+
+func (t *trustedIssuer) renderIdentity(iss, sub string, extra map[string]any) (string, error) {
+	var buf bytes.Buffer
+	if err := t.identity.Execute(&buf, claims{Iss: iss, Sub: sub, Extra: extra}); err != nil {
+		return "", fmt.Errorf("failed to render identity claim template: %w", err)
+	}
+
+	identity := strings.TrimSpace(buf.String())
+	if identity == "" {
+		return "", fmt.Errorf("identity claim template produced an empty identity")
+	}
+
+	return identity, nil
+}
+
+func bearerToken(ctx context.Context) (string, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", fmt.Errorf("no metadata found in request")
+	}
+
+	for _, v := range md.Get("authorization") {
+		if strings.HasPrefix(v, bearerPrefix) {
+			return strings.TrimPrefix(v, bearerPrefix), nil
+		}
+	}
+
+	return "", fmt.Errorf("no bearer token found in authorization header")
+}
+
+func joinErrs(errs []error) error {
+	msgs := make([]string, 0, len(errs))
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Errorf("%s", strings.Join(msgs, "; "))
+}
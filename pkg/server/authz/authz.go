@@ -0,0 +1,266 @@
+/*
+Copyright 2021 The cert-manager Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package authz implements a policy engine that runs after authentication in
+// Server.authRequest and decides which SPIFFE URIs, DNS names, key usages
+// and certificate durations an authenticated caller may request. Its rules
+// can be scoped to a namespace, ServiceAccount, or caller identity, similar
+// in spirit to scope-based token authorization. It runs after, and in
+// addition to, the "identities-must-match" check in authRequest; it does not
+// replace that check.
+package authz
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"istio.io/istio/pkg/security"
+)
+
+// keyUsageOID is the well-known OID of the keyUsage extension.
+var keyUsageOID = asn1.ObjectIdentifier{2, 5, 29, 15}
+
+// Match selects which authenticated callers a Rule applies to. A Rule
+// matches a caller if every non-empty field here matches; CallerIdentity,
+// Namespace and ServiceAccount may each be left empty to match any value.
+type Match struct {
+	CallerIdentity string
+	Namespace      string
+	ServiceAccount string
+}
+
+// Allow describes what a matching caller is permitted to request.
+type Allow struct {
+	// SPIFFEURIPatterns are SPIFFE-path glob patterns (e.g.
+	// "spiffe://cluster.local/ns/x/sa/*") that every URI SAN in the CSR
+	// must match at least one of.
+	SPIFFEURIPatterns []string
+	// MaxDuration is the maximum validity duration the caller may
+	// request. A zero value means no limit is enforced.
+	MaxDuration time.Duration
+	// AllowedDNSNames are the DNS SANs a caller may request. An empty
+	// list means no DNS SANs are permitted.
+	AllowedDNSNames []string
+	// AllowedKeyUsages bounds which keyUsage extension bits a caller may
+	// request via the CSR's keyUsage attribute. A zero value means no
+	// key usages are permitted; callers not requesting any keyUsage bits
+	// at all are unaffected by this field.
+	AllowedKeyUsages x509.KeyUsage
+}
+
+// Rule binds a Match to what it Allows.
+type Rule struct {
+	Match Match
+	Allow Allow
+}
+
+// Request is the subset of an authenticated CSR that the Engine evaluates.
+type Request struct {
+	Caller *security.Caller
+	// Identity is the comma-joined display form of Identities, used only
+	// for logging; matching against Match.CallerIdentity is done against
+	// Identities instead, since a caller may hold more than one identity
+	// (e.g. trust-domain aliases) and none of them individually equal the
+	// joined string.
+	Identity          string
+	Identities        []string
+	URIs              []string
+	DNSNames          []string
+	RequestedKeyUsage x509.KeyUsage
+	RequestedDuration time.Duration
+}
+
+// Engine evaluates a Request against a set of Rules.
+type Engine struct {
+	rules         []Rule
+	dryRun        bool
+	denyByDefault bool
+	log           func(format string, args ...any)
+}
+
+// Option configures an Engine.
+type Option func(*Engine)
+
+// WithDryRun causes the Engine to log would-deny decisions without
+// blocking the request.
+func WithDryRun(dryRun bool) Option {
+	return func(e *Engine) { e.dryRun = dryRun }
+}
+
+// WithDenyByDefault causes the Engine to deny any caller that doesn't match
+// at least one Rule. When false, unmatched callers are allowed through,
+// preserving pre-authz behaviour.
+func WithDenyByDefault(deny bool) Option {
+	return func(e *Engine) { e.denyByDefault = deny }
+}
+
+// WithLogger sets the function used to report dry-run decisions.
+func WithLogger(log func(format string, args ...any)) Option {
+	return func(e *Engine) { e.log = log }
+}
+
+// NewEngine builds an Engine from a set of rules loaded from, e.g., an
+// IstioCSRPolicy CRD or a static file.
+func NewEngine(rules []Rule, opts ...Option) *Engine {
+	e := &Engine{rules: rules, log: func(string, ...any) {}}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// Evaluate returns nil if req is authorized by at least one matching Rule,
+// or an error describing why it was denied. In dry-run mode, Evaluate
+// always returns nil but logs what would have been denied.
+func (e *Engine) Evaluate(req Request) error {
+	err := e.evaluate(req)
+	if err == nil {
+		return nil
+	}
+
+	if e.dryRun {
+		e.log("authz dry-run: would deny request for identity %q: %v", req.Identity, err)
+		return nil
+	}
+
+	return err
+}
+
+func (e *Engine) evaluate(req Request) error {
+	matched := false
+
+	for _, rule := range e.rules {
+		if !rule.Match.matches(req) {
+			continue
+		}
+		matched = true
+
+		if err := rule.Allow.authorize(req); err != nil {
+			continue
+		}
+
+		return nil
+	}
+
+	if !matched {
+		if e.denyByDefault {
+			return fmt.Errorf("no authz policy matched identity %q", req.Identity)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("no matching authz policy authorized the request for identity %q", req.Identity)
+}
+
+func (m Match) matches(req Request) bool {
+	if m.CallerIdentity != "" && !contains(req.Identities, m.CallerIdentity) {
+		return false
+	}
+
+	k8s := req.Caller.KubernetesInfo
+	if m.Namespace != "" && m.Namespace != k8s.PodNamespace {
+		return false
+	}
+	if m.ServiceAccount != "" && m.ServiceAccount != k8s.PodServiceAccount {
+		return false
+	}
+
+	return true
+}
+
+func (a Allow) authorize(req Request) error {
+	for _, uri := range req.URIs {
+		if !matchesAny(a.SPIFFEURIPatterns, uri) {
+			return fmt.Errorf("uri SAN %q not permitted by policy", uri)
+		}
+	}
+
+	for _, dns := range req.DNSNames {
+		if !contains(a.AllowedDNSNames, dns) {
+			return fmt.Errorf("dns SAN %q not permitted by policy", dns)
+		}
+	}
+
+	if disallowed := req.RequestedKeyUsage &^ a.AllowedKeyUsages; disallowed != 0 {
+		return fmt.Errorf("requested key usage %v is not permitted by policy", disallowed)
+	}
+
+	if a.MaxDuration > 0 && req.RequestedDuration > a.MaxDuration {
+		return fmt.Errorf("requested duration %s exceeds policy maximum %s", req.RequestedDuration, a.MaxDuration)
+	}
+
+	return nil
+}
+
+// matchesAny reports whether uri matches at least one of patterns, using
+// SPIFFE path glob semantics: "*" matches a single path segment, mirroring
+// path.Match.
+func matchesAny(patterns []string, uri string) bool {
+	for _, pattern := range patterns {
+		ok, err := path.Match(pattern, uri)
+		if err == nil && ok {
+			return true
+		}
+		// Also allow a trailing "/*" to match any suffix beneath the
+		// prefix, not just a single path segment.
+		if prefix, found := strings.CutSuffix(pattern, "/*"); found && strings.HasPrefix(uri, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// ExtractRequestedKeyUsage parses the keyUsage extension attribute carried
+// on a CSR, if any, returning 0 if the CSR did not request one. Most CSRs
+// don't carry this attribute at all, since key usage is normally assigned
+// by the signer rather than requested by the caller; when present, it
+// becomes part of what the Engine evaluates against AllowedKeyUsages.
+func ExtractRequestedKeyUsage(exts []pkix.Extension) x509.KeyUsage {
+	for _, ext := range exts {
+		if !ext.Id.Equal(keyUsageOID) {
+			continue
+		}
+
+		var bits asn1.BitString
+		if _, err := asn1.Unmarshal(ext.Value, &bits); err != nil {
+			return 0
+		}
+
+		var usage int
+		for i := 0; i < 9; i++ {
+			if bits.At(i) != 0 {
+				usage |= 1 << uint(i)
+			}
+		}
+		return x509.KeyUsage(usage)
+	}
+
+	return 0
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
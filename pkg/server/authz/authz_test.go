@@ -0,0 +1,274 @@
+/*
+Copyright 2021 The cert-manager Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package authz
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"testing"
+	"time"
+
+	"istio.io/istio/pkg/security"
+
+	"github.com/cert-manager/istio-csr/pkg/server/internal/extensions"
+)
+
+func TestEngine_Evaluate(t *testing.T) {
+	rules := []Rule{
+		{
+			Match: Match{Namespace: "x"},
+			Allow: Allow{
+				SPIFFEURIPatterns: []string{"spiffe://td/ns/x/sa/*"},
+				MaxDuration:       time.Hour,
+				AllowedKeyUsages:  x509.KeyUsageDigitalSignature,
+			},
+		},
+	}
+
+	tests := map[string]struct {
+		engine  *Engine
+		req     Request
+		wantErr bool
+	}{
+		"allowed uri and duration within policy": {
+			engine: NewEngine(rules),
+			req: Request{
+				Caller:            &security.Caller{KubernetesInfo: security.KubernetesInfo{PodNamespace: "x"}},
+				Identity:          "spiffe://td/ns/x/sa/a",
+				URIs:              []string{"spiffe://td/ns/x/sa/a"},
+				RequestedDuration: time.Minute,
+			},
+			wantErr: false,
+		},
+		"uri outside allowed pattern is denied": {
+			engine: NewEngine(rules),
+			req: Request{
+				Caller:   &security.Caller{KubernetesInfo: security.KubernetesInfo{PodNamespace: "x"}},
+				Identity: "spiffe://td/ns/x/sa/a",
+				URIs:     []string{"spiffe://td/ns/kube-system/sa/a"},
+			},
+			wantErr: true,
+		},
+		"duration exceeding policy maximum is denied": {
+			engine: NewEngine(rules),
+			req: Request{
+				Caller:            &security.Caller{KubernetesInfo: security.KubernetesInfo{PodNamespace: "x"}},
+				Identity:          "spiffe://td/ns/x/sa/a",
+				URIs:              []string{"spiffe://td/ns/x/sa/a"},
+				RequestedDuration: 2 * time.Hour,
+			},
+			wantErr: true,
+		},
+		"unmatched caller is allowed when deny-by-default is off": {
+			engine: NewEngine(rules),
+			req: Request{
+				Caller:   &security.Caller{KubernetesInfo: security.KubernetesInfo{PodNamespace: "other"}},
+				Identity: "spiffe://td/ns/other/sa/a",
+				URIs:     []string{"spiffe://td/ns/other/sa/a"},
+			},
+			wantErr: false,
+		},
+		"unmatched caller is denied when deny-by-default is on": {
+			engine: NewEngine(rules, WithDenyByDefault(true)),
+			req: Request{
+				Caller:   &security.Caller{KubernetesInfo: security.KubernetesInfo{PodNamespace: "other"}},
+				Identity: "spiffe://td/ns/other/sa/a",
+				URIs:     []string{"spiffe://td/ns/other/sa/a"},
+			},
+			wantErr: true,
+		},
+		"dry run never blocks": {
+			engine: NewEngine(rules, WithDryRun(true)),
+			req: Request{
+				Caller:   &security.Caller{KubernetesInfo: security.KubernetesInfo{PodNamespace: "x"}},
+				Identity: "spiffe://td/ns/x/sa/a",
+				URIs:     []string{"spiffe://td/ns/kube-system/sa/a"},
+			},
+			wantErr: false,
+		},
+		"requested key usage within policy is allowed": {
+			engine: NewEngine(rules),
+			req: Request{
+				Caller:            &security.Caller{KubernetesInfo: security.KubernetesInfo{PodNamespace: "x"}},
+				Identity:          "spiffe://td/ns/x/sa/a",
+				URIs:              []string{"spiffe://td/ns/x/sa/a"},
+				RequestedKeyUsage: x509.KeyUsageDigitalSignature,
+			},
+			wantErr: false,
+		},
+		"requested key usage outside policy is denied": {
+			engine: NewEngine(rules),
+			req: Request{
+				Caller:            &security.Caller{KubernetesInfo: security.KubernetesInfo{PodNamespace: "x"}},
+				Identity:          "spiffe://td/ns/x/sa/a",
+				URIs:              []string{"spiffe://td/ns/x/sa/a"},
+				RequestedKeyUsage: x509.KeyUsageCertSign,
+			},
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.engine.Evaluate(tc.req)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Evaluate() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+// TestEngine_Evaluate_CallerIdentityMatchesAnyIdentity ensures a rule scoped
+// by Match.CallerIdentity matches a caller authenticated with more than one
+// identity (e.g. trust-domain aliases), so long as one of them equals
+// CallerIdentity - it must not require the comma-joined display form to
+// match exactly.
+func TestEngine_Evaluate_CallerIdentityMatchesAnyIdentity(t *testing.T) {
+	rules := []Rule{
+		{
+			Match: Match{CallerIdentity: "spiffe://td/ns/x/sa/alias"},
+			Allow: Allow{SPIFFEURIPatterns: []string{"spiffe://td/ns/x/sa/*"}},
+		},
+	}
+
+	req := Request{
+		Caller:   &security.Caller{KubernetesInfo: security.KubernetesInfo{PodNamespace: "x"}},
+		Identity: "spiffe://td/ns/x/sa/primary,spiffe://td/ns/x/sa/alias",
+		Identities: []string{
+			"spiffe://td/ns/x/sa/primary",
+			"spiffe://td/ns/x/sa/alias",
+		},
+		URIs: []string{"spiffe://td/ns/x/sa/primary"},
+	}
+
+	if err := NewEngine(rules).Evaluate(req); err != nil {
+		t.Errorf("expected rule scoped to one of the caller's aliases to match, got error: %v", err)
+	}
+
+	if err := NewEngine(rules, WithDenyByDefault(true)).Evaluate(Request{
+		Caller:     &security.Caller{KubernetesInfo: security.KubernetesInfo{PodNamespace: "x"}},
+		Identity:   "spiffe://td/ns/x/sa/other,spiffe://td/ns/x/sa/other2",
+		Identities: []string{"spiffe://td/ns/x/sa/other", "spiffe://td/ns/x/sa/other2"},
+		URIs:       []string{"spiffe://td/ns/x/sa/other"},
+	}); err == nil {
+		t.Errorf("expected a caller with no matching alias to be denied under deny-by-default")
+	}
+}
+
+func TestExtractRequestedKeyUsage(t *testing.T) {
+	exts := []pkix.Extension{
+		{
+			Id:       keyUsageOID,
+			Critical: true,
+			Value:    marshalKeyUsage(t, x509.KeyUsageDigitalSignature|x509.KeyUsageKeyEncipherment),
+		},
+	}
+
+	if got := ExtractRequestedKeyUsage(exts); got != x509.KeyUsageDigitalSignature|x509.KeyUsageKeyEncipherment {
+		t.Errorf("ExtractRequestedKeyUsage() = %v, want %v", got, x509.KeyUsageDigitalSignature|x509.KeyUsageKeyEncipherment)
+	}
+
+	if got := ExtractRequestedKeyUsage(nil); got != 0 {
+		t.Errorf("ExtractRequestedKeyUsage(nil) = %v, want 0", got)
+	}
+}
+
+// TestValidateCSRExtentionsThenEvaluate_CriticalKeyUsageIsReachable is an
+// integration-style test covering the two checks authRequest runs in
+// sequence: extensions.ValidateCSRExtentions must not reject a CSR that
+// marks keyUsage critical (as RFC 5280 recommends) before the requested
+// usage ever reaches Engine.Evaluate's AllowedKeyUsages enforcement.
+func TestValidateCSRExtentionsThenEvaluate_CriticalKeyUsageIsReachable(t *testing.T) {
+	template := &x509.CertificateRequest{
+		ExtraExtensions: []pkix.Extension{
+			{
+				Id:       keyUsageOID,
+				Critical: true,
+				Value:    marshalKeyUsage(t, x509.KeyUsageCertSign),
+			},
+		},
+	}
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, priv)
+	if err != nil {
+		t.Fatalf("failed to create CSR: %v", err)
+	}
+
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		t.Fatalf("failed to parse CSR: %v", err)
+	}
+
+	identities := []string{"spiffe://td/ns/x/sa/a"}
+
+	if err := extensions.ValidateCSRExtentions(csr, identities, extensions.DefaultSANPolicy()); err != nil {
+		t.Fatalf("expected critical keyUsage extension to pass the default SAN policy, got error: %v", err)
+	}
+
+	rules := []Rule{
+		{
+			Match: Match{Namespace: "x"},
+			Allow: Allow{AllowedKeyUsages: x509.KeyUsageDigitalSignature},
+		},
+	}
+
+	req := Request{
+		Caller:            &security.Caller{KubernetesInfo: security.KubernetesInfo{PodNamespace: "x"}},
+		Identity:          identities[0],
+		Identities:        identities,
+		RequestedKeyUsage: ExtractRequestedKeyUsage(csr.Extensions),
+	}
+
+	if err := NewEngine(rules).Evaluate(req); err == nil {
+		t.Errorf("expected CertSign, requested via a critical keyUsage extension, to be denied by an AllowedKeyUsages policy that doesn't permit it")
+	}
+}
+
+func marshalKeyUsage(t *testing.T, usage x509.KeyUsage) []byte {
+	t.Helper()
+
+	var numBits int
+	for i := 8; i >= 0; i-- {
+		if usage&(1<<uint(i)) != 0 {
+			numBits = i + 1
+			break
+		}
+	}
+
+	numBytes := (numBits + 7) / 8
+	bytesVal := make([]byte, numBytes)
+	for i := 0; i < numBits; i++ {
+		if usage&(1<<uint(i)) != 0 {
+			bytesVal[i/8] |= 1 << uint(7-i%8)
+		}
+	}
+
+	bits := asn1.BitString{Bytes: bytesVal, BitLength: numBits}
+	value, err := asn1.Marshal(bits)
+	if err != nil {
+		t.Fatalf("failed to marshal key usage: %v", err)
+	}
+	return value
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2021 The cert-manager Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package algorithm implements a policy registry which restricts the set of
+// public key and signature algorithms that istio-csr will accept on an
+// incoming CertificateRequest, similar in spirit to Fulcio's per-issuer
+// algorithm allow-lists.
+package algorithm
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+)
+
+// Family identifies a public key algorithm and, where relevant, its minimum
+// key strength.
+type Family string
+
+const (
+	ECDSAP256 Family = "ecdsa-p256"
+	ECDSAP384 Family = "ecdsa-p384"
+	RSA3072   Family = "rsa-3072"
+	RSA4096   Family = "rsa-4096"
+	Ed25519   Family = "ed25519"
+)
+
+// Registry holds the set of public key/signature algorithms that istio-csr
+// will accept, along with optional per-identity overrides which allow
+// specific identities to be pinned to a stronger set of algorithms than the
+// default. Overrides are looked up by the exact identity string; a trust
+// domain on its own is not matched.
+type Registry struct {
+	// allowed is the default set of families accepted for any identity not
+	// present in overrides.
+	allowed map[Family]struct{}
+
+	// overrides maps a caller identity to the set of families it is
+	// permitted to use, taking precedence over allowed.
+	overrides map[string]map[Family]struct{}
+}
+
+// NewRegistry builds an algorithm Registry from a default allow-list and an
+// optional set of per-identity overrides.
+func NewRegistry(allowed []Family, overrides map[string][]Family) *Registry {
+	r := &Registry{
+		allowed:   toSet(allowed),
+		overrides: make(map[string]map[Family]struct{}, len(overrides)),
+	}
+
+	for identity, families := range overrides {
+		r.overrides[identity] = toSet(families)
+	}
+
+	return r
+}
+
+func toSet(families []Family) map[Family]struct{} {
+	set := make(map[Family]struct{}, len(families))
+	for _, f := range families {
+		set[f] = struct{}{}
+	}
+	return set
+}
+
+// Validate determines the Family of the given CSR's public key and returns
+// an error if it falls outside the allow-list configured for identities.
+// SHA-1 based signature algorithms are always rejected, regardless of
+// configuration; no other signature algorithm is restricted here, since the
+// allow-list holds key families only.
+//
+// identities is checked one entry at a time, rather than as a single
+// joined string: a caller can be authenticated with more than one
+// identity (e.g. trust-domain aliases), and a per-identity override must
+// still apply to that caller even though none of its individual
+// identities equal the full, comma-joined display string.
+func (r *Registry) Validate(identities []string, csr *x509.CertificateRequest) error {
+	if csr.SignatureAlgorithm == x509.SHA1WithRSA || csr.SignatureAlgorithm == x509.ECDSAWithSHA1 || csr.SignatureAlgorithm == x509.DSAWithSHA1 {
+		return fmt.Errorf("signature algorithm %s is forbidden", csr.SignatureAlgorithm)
+	}
+
+	family, err := keyFamily(csr.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	for _, identity := range identities {
+		allowed := r.allowed
+		if override, ok := r.overrides[identity]; ok {
+			allowed = override
+		}
+
+		if _, ok := allowed[family]; !ok {
+			return fmt.Errorf("public key algorithm %s is not permitted for identity %q", family, identity)
+		}
+	}
+
+	return nil
+}
+
+// keyFamily inspects a parsed public key and returns the Family it belongs
+// to, or an error if the key type or strength is not recognised.
+func keyFamily(pub crypto.PublicKey) (Family, error) {
+	switch pk := pub.(type) {
+	case *ecdsa.PublicKey:
+		switch pk.Curve {
+		case elliptic.P256():
+			return ECDSAP256, nil
+		case elliptic.P384():
+			return ECDSAP384, nil
+		default:
+			return "", fmt.Errorf("ecdsa curve %s is not permitted", pk.Curve.Params().Name)
+		}
+
+	case *rsa.PublicKey:
+		switch {
+		case pk.N.BitLen() >= 4096:
+			return RSA4096, nil
+		case pk.N.BitLen() >= 3072:
+			return RSA3072, nil
+		default:
+			return "", fmt.Errorf("rsa key size %d is below the minimum of 3072 bits", pk.N.BitLen())
+		}
+
+	case ed25519.PublicKey:
+		return Ed25519, nil
+
+	default:
+		return "", fmt.Errorf("unsupported public key type %T", pub)
+	}
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2021 The cert-manager Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package algorithm
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"testing"
+)
+
+func mustCSR(t *testing.T, key crypto.Signer, sigAlg x509.SignatureAlgorithm) *x509.CertificateRequest {
+	t.Helper()
+
+	template := &x509.CertificateRequest{SignatureAlgorithm: sigAlg}
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	if err != nil {
+		t.Fatalf("failed to create CSR: %v", err)
+	}
+
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		t.Fatalf("failed to parse CSR: %v", err)
+	}
+
+	return csr
+}
+
+func TestRegistry_Validate(t *testing.T) {
+	ecdsaP224Key, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecdsaP256Key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecdsaP384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsa2048Key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsa3072Key, err := rsa.GenerateKey(rand.Reader, 3072)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsa4096Key, err := rsa.GenerateKey(rand.Reader, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reg := NewRegistry([]Family{ECDSAP256, ECDSAP384, RSA3072, RSA4096, Ed25519}, map[string][]Family{
+		"spiffe://cluster.local/ns/secure/sa/strong": {RSA4096},
+	})
+
+	tests := map[string]struct {
+		identities []string
+		key        crypto.Signer
+		sigAlg     x509.SignatureAlgorithm
+		wantErr    bool
+	}{
+		"ecdsa p224 is rejected": {
+			identities: []string{"spiffe://cluster.local/ns/default/sa/a"},
+			key:        ecdsaP224Key,
+			sigAlg:     x509.ECDSAWithSHA256,
+			wantErr:    true,
+		},
+		"ecdsa p256 is accepted": {
+			identities: []string{"spiffe://cluster.local/ns/default/sa/a"},
+			key:        ecdsaP256Key,
+			sigAlg:     x509.ECDSAWithSHA256,
+			wantErr:    false,
+		},
+		"ecdsa p384 is accepted": {
+			identities: []string{"spiffe://cluster.local/ns/default/sa/a"},
+			key:        ecdsaP384Key,
+			sigAlg:     x509.ECDSAWithSHA384,
+			wantErr:    false,
+		},
+		"rsa 2048 is rejected": {
+			identities: []string{"spiffe://cluster.local/ns/default/sa/a"},
+			key:        rsa2048Key,
+			sigAlg:     x509.SHA256WithRSA,
+			wantErr:    true,
+		},
+		"rsa 3072 is accepted": {
+			identities: []string{"spiffe://cluster.local/ns/default/sa/a"},
+			key:        rsa3072Key,
+			sigAlg:     x509.SHA256WithRSA,
+			wantErr:    false,
+		},
+		"ed25519 is accepted": {
+			identities: []string{"spiffe://cluster.local/ns/default/sa/a"},
+			key:        ed25519Key,
+			sigAlg:     x509.PureEd25519,
+			wantErr:    false,
+		},
+		"sha1 signature is always rejected": {
+			identities: []string{"spiffe://cluster.local/ns/default/sa/a"},
+			key:        rsa3072Key,
+			sigAlg:     x509.SHA1WithRSA,
+			wantErr:    true,
+		},
+		"per-identity override requires rsa 4096": {
+			identities: []string{"spiffe://cluster.local/ns/secure/sa/strong"},
+			key:        rsa3072Key,
+			sigAlg:     x509.SHA256WithRSA,
+			wantErr:    true,
+		},
+		"override still applies when caller has multiple identities": {
+			identities: []string{
+				"spiffe://cluster.local/ns/default/sa/a",
+				"spiffe://cluster.local/ns/secure/sa/strong",
+			},
+			key:     rsa3072Key,
+			sigAlg:  x509.SHA256WithRSA,
+			wantErr: true,
+		},
+		"multiple identities all satisfying their policies are accepted": {
+			identities: []string{
+				"spiffe://cluster.local/ns/default/sa/a",
+				"spiffe://cluster.local/ns/secure/sa/strong",
+			},
+			key:     rsa4096Key,
+			sigAlg:  x509.SHA256WithRSA,
+			wantErr: false,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			csr := mustCSR(t, tc.key, tc.sigAlg)
+
+			err := reg.Validate(tc.identities, csr)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+/*
+Copyright 2021 The cert-manager Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package extensions validates the X.509 extensions and subject alternative
+// names carried by an incoming CertificateRequest, rejecting anything that
+// could allow a caller to smuggle unexpected identity data past the
+// identity/URI matching in authRequest.
+package extensions
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+)
+
+// defaultMaxURILength is the default cap on the length of a single URI SAN,
+// in bytes.
+const defaultMaxURILength = 2048
+
+// subjectAltNameOID is the well-known OID of the subjectAltName extension,
+// which is always permitted as critical since it is required to carry the
+// SPIFFE URI SAN.
+var subjectAltNameOID = asn1.ObjectIdentifier{2, 5, 29, 17}
+
+// keyUsageOID is the well-known OID of the keyUsage extension. RFC 5280
+// recommends CAs mark it critical when present, so it is always permitted
+// as critical alongside subjectAltName: otherwise a CSR that follows that
+// recommendation would be rejected here before authz.Engine ever gets a
+// chance to enforce Allow.AllowedKeyUsages against it.
+var keyUsageOID = asn1.ObjectIdentifier{2, 5, 29, 15}
+
+// SANPolicy bounds the subject alternative names and critical extensions
+// istio-csr will accept on a CSR. The zero value applies the same bounds as
+// DefaultSANPolicy (a single URI, capped at defaultMaxURILength) and rejects
+// unrecognised critical extensions.
+type SANPolicy struct {
+	// MaxURIs is the maximum number of URI SANs permitted. Defaults to 1.
+	MaxURIs int
+	// MaxURILength is the maximum length, in bytes, of any single URI SAN.
+	// Defaults to defaultMaxURILength.
+	MaxURILength int
+	// MaxTotalSANLength bounds the combined length, in bytes, of all URI
+	// SANs. A zero value means no combined limit is enforced.
+	MaxTotalSANLength int
+	// AllowMultipleURIs allows more than one URI SAN when true. Disabled
+	// by default; see AllowMultipleURIsForIdentity for a per-identity
+	// override.
+	AllowMultipleURIs bool
+	// AllowMultipleURIsForIdentity overrides AllowMultipleURIs for
+	// specific caller identities.
+	AllowMultipleURIsForIdentity map[string]bool
+	// AllowedCriticalOIDs is the set of critical extension OIDs that are
+	// permitted in addition to subjectAltName and keyUsage. Any other critical
+	// extension causes the CSR to be rejected.
+	AllowedCriticalOIDs []asn1.ObjectIdentifier
+}
+
+// DefaultSANPolicy returns the policy applied when no SANPolicy has been
+// configured: a single URI SAN, capped at defaultMaxURILength bytes.
+func DefaultSANPolicy() SANPolicy {
+	return SANPolicy{
+		MaxURIs:      1,
+		MaxURILength: defaultMaxURILength,
+	}
+}
+
+// ValidateCSRExtentions ensures csr carries only known, permitted critical
+// extensions, and that its SANs conform to policy. identities are the
+// caller's authenticated identities (as established by authRequest), used
+// to look up any per-identity SANPolicy override; they must never be
+// derived from the CSR itself, since the CSR is unauthenticated at the
+// point this is called.
+func ValidateCSRExtentions(csr *x509.CertificateRequest, identities []string, policy SANPolicy) error {
+	if err := validateCriticalExtensions(csr.Extensions, policy.AllowedCriticalOIDs); err != nil {
+		return err
+	}
+
+	return validateSANs(csr, identities, policy)
+}
+
+func validateCriticalExtensions(exts []pkix.Extension, allowed []asn1.ObjectIdentifier) error {
+	for _, ext := range exts {
+		if !ext.Critical {
+			continue
+		}
+		if ext.Id.Equal(subjectAltNameOID) || ext.Id.Equal(keyUsageOID) {
+			continue
+		}
+		if !oidAllowed(ext.Id, allowed) {
+			return fmt.Errorf("critical extension %s is not on the allowed list", ext.Id)
+		}
+	}
+	return nil
+}
+
+func oidAllowed(id asn1.ObjectIdentifier, allowed []asn1.ObjectIdentifier) bool {
+	for _, a := range allowed {
+		if id.Equal(a) {
+			return true
+		}
+	}
+	return false
+}
+
+func validateSANs(csr *x509.CertificateRequest, identities []string, policy SANPolicy) error {
+	maxURIs := policy.MaxURIs
+	if maxURIs == 0 {
+		maxURIs = 1
+	}
+
+	maxURILength := policy.MaxURILength
+	if maxURILength == 0 {
+		maxURILength = defaultMaxURILength
+	}
+
+	// A caller may be authenticated with more than one identity (e.g.
+	// trust-domain aliases), so the override is looked up per-identity
+	// rather than against a single joined string. If more than one of the
+	// caller's identities has an override configured and they disagree,
+	// the most permissive (true) wins.
+	allowMultiple := policy.AllowMultipleURIs
+	var sawOverride bool
+	for _, identity := range identities {
+		override, ok := policy.AllowMultipleURIsForIdentity[identity]
+		if !ok {
+			continue
+		}
+		if !sawOverride {
+			allowMultiple = override
+			sawOverride = true
+			continue
+		}
+		if override {
+			allowMultiple = true
+		}
+	}
+
+	if !allowMultiple && len(csr.URIs) > 1 {
+		return fmt.Errorf("multiple URI SANs are not permitted: got %d", len(csr.URIs))
+	}
+
+	if len(csr.URIs) > maxURIs {
+		return fmt.Errorf("too many URI SANs: got %d, maximum %d", len(csr.URIs), maxURIs)
+	}
+
+	var total int
+	for _, uri := range csr.URIs {
+		s := uri.String()
+		if len(s) > maxURILength {
+			return fmt.Errorf("URI SAN %q exceeds maximum length of %d bytes", s, maxURILength)
+		}
+		total += len(s)
+	}
+
+	if policy.MaxTotalSANLength > 0 && total > policy.MaxTotalSANLength {
+		return fmt.Errorf("combined URI SAN length %d exceeds maximum of %d bytes", total, policy.MaxTotalSANLength)
+	}
+
+	return nil
+}
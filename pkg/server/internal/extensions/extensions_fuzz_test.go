@@ -0,0 +1,86 @@
+/*
+Copyright 2021 The cert-manager Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package extensions
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"net/url"
+	"testing"
+)
+
+// FuzzValidateCSRExtentions generates CSRs with malformed SANs - too many
+// URI SANs, over-long URIs, and unknown critical extensions - and asserts
+// that ValidateCSRExtentions rejects every one of them under the default
+// policy before the CSR would ever reach signing.
+func FuzzValidateCSRExtentions(f *testing.F) {
+	f.Add(2, 4096, true)
+	f.Add(1, 8192, false)
+	f.Add(5, 1, true)
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	f.Fuzz(func(t *testing.T, numURIs int, uriLen int, addUnknownCritical bool) {
+		if numURIs < 0 || numURIs > 64 {
+			t.Skip()
+		}
+		if uriLen < 0 || uriLen > 1<<20 {
+			t.Skip()
+		}
+
+		template := &x509.CertificateRequest{}
+		for i := 0; i < numURIs; i++ {
+			template.URIs = append(template.URIs, &url.URL{
+				Scheme: "spiffe",
+				Host:   "cluster.local",
+				Path:   "/ns/default/sa/" + string(make([]byte, uriLen)),
+			})
+		}
+		if addUnknownCritical {
+			template.ExtraExtensions = append(template.ExtraExtensions, pkix.Extension{
+				Id:       asn1.ObjectIdentifier{1, 2, 3, 4, 5},
+				Critical: true,
+				Value:    []byte{0x05, 0x00},
+			})
+		}
+
+		der, err := x509.CreateCertificateRequest(rand.Reader, template, priv)
+		if err != nil {
+			// Some generated templates may not be encodable; that is not
+			// what this fuzz target is exercising.
+			t.Skip()
+		}
+
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil {
+			t.Skip()
+		}
+
+		err = ValidateCSRExtentions(csr, []string{"spiffe://cluster.local/ns/default/sa/caller"}, DefaultSANPolicy())
+
+		malformed := numURIs > 1 || (numURIs > 0 && uriLen > defaultMaxURILength) || addUnknownCritical
+		if malformed && err == nil {
+			t.Errorf("expected malformed CSR (uris=%d, uriLen=%d, unknownCritical=%v) to be rejected", numURIs, uriLen, addUnknownCritical)
+		}
+	})
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2021 The cert-manager Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package extensions
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"testing"
+)
+
+func mustCSRWithURIs(t *testing.T, uris ...string) *x509.CertificateRequest {
+	t.Helper()
+
+	template := &x509.CertificateRequest{}
+	for _, u := range uris {
+		parsed, err := url.Parse(u)
+		if err != nil {
+			t.Fatalf("failed to parse uri %q: %v", u, err)
+		}
+		template.URIs = append(template.URIs, parsed)
+	}
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, priv)
+	if err != nil {
+		t.Fatalf("failed to create CSR: %v", err)
+	}
+
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		t.Fatalf("failed to parse CSR: %v", err)
+	}
+
+	return csr
+}
+
+func TestValidateCSRExtentions_AllowMultipleURIsForIdentity(t *testing.T) {
+	policy := SANPolicy{
+		MaxURIs: 2,
+		AllowMultipleURIsForIdentity: map[string]bool{
+			"spiffe://cluster.local/ns/trusted/sa/multi": true,
+		},
+	}
+
+	multiURICSR := mustCSRWithURIs(t,
+		"spiffe://cluster.local/ns/default/sa/a",
+		"spiffe://cluster.local/ns/default/sa/b",
+	)
+
+	if err := ValidateCSRExtentions(multiURICSR, []string{"spiffe://cluster.local/ns/trusted/sa/multi"}, policy); err != nil {
+		t.Errorf("expected override identity to be permitted multiple URIs, got error: %v", err)
+	}
+
+	if err := ValidateCSRExtentions(multiURICSR, []string{"spiffe://cluster.local/ns/default/sa/a"}, policy); err == nil {
+		t.Errorf("expected non-overridden identity to be rejected for multiple URIs")
+	}
+}
+
+// TestValidateCSRExtentions_AllowMultipleURIsForIdentity_MultiIdentityCaller
+// ensures the override lookup checks every identity a caller is
+// authenticated with, not just a single joined string - a caller holding
+// both an overridden and a non-overridden identity (e.g. trust-domain
+// aliases) must still get the override applied.
+func TestValidateCSRExtentions_AllowMultipleURIsForIdentity_MultiIdentityCaller(t *testing.T) {
+	policy := SANPolicy{
+		MaxURIs: 2,
+		AllowMultipleURIsForIdentity: map[string]bool{
+			"spiffe://cluster.local/ns/trusted/sa/multi": true,
+		},
+	}
+
+	multiURICSR := mustCSRWithURIs(t,
+		"spiffe://cluster.local/ns/default/sa/a",
+		"spiffe://cluster.local/ns/default/sa/b",
+	)
+
+	identities := []string{
+		"spiffe://cluster.local/ns/default/sa/a",
+		"spiffe://cluster.local/ns/trusted/sa/multi",
+	}
+
+	if err := ValidateCSRExtentions(multiURICSR, identities, policy); err != nil {
+		t.Errorf("expected override to apply when it matches any of the caller's identities, got error: %v", err)
+	}
+}
+
+// TestValidateCSRExtentions_OverrideIsNotCSRControlled ensures the override
+// lookup is keyed strictly by the authenticated identity passed in by
+// authRequest, not by anything read back out of the (unauthenticated) CSR
+// itself - an attacker must not be able to flip AllowMultipleURIs by
+// putting a privileged identity in their own URI SAN.
+func TestValidateCSRExtentions_OverrideIsNotCSRControlled(t *testing.T) {
+	policy := SANPolicy{
+		MaxURIs: 2,
+		AllowMultipleURIsForIdentity: map[string]bool{
+			"spiffe://cluster.local/ns/trusted/sa/multi": true,
+		},
+	}
+
+	// The CSR's first URI SAN claims the privileged identity, but the
+	// authenticated caller is someone else entirely.
+	spoofedCSR := mustCSRWithURIs(t,
+		"spiffe://cluster.local/ns/trusted/sa/multi",
+		"spiffe://cluster.local/ns/attacker/sa/evil",
+	)
+
+	if err := ValidateCSRExtentions(spoofedCSR, []string{"spiffe://cluster.local/ns/attacker/sa/evil"}, policy); err == nil {
+		t.Errorf("expected multiple URIs to be rejected when the authenticated identity has no override, regardless of CSR content")
+	}
+}
+
+// TestValidateCSRExtentions_KeyUsageCriticalIsAllowedByDefault ensures a CSR
+// that marks its keyUsage extension critical - as RFC 5280 recommends - is
+// not rejected by the default SAN policy before authz.Engine gets a chance
+// to evaluate Allow.AllowedKeyUsages against the requested usage.
+func TestValidateCSRExtentions_KeyUsageCriticalIsAllowedByDefault(t *testing.T) {
+	template := &x509.CertificateRequest{
+		ExtraExtensions: []pkix.Extension{
+			{
+				Id:       keyUsageOID,
+				Critical: true,
+				Value:    []byte{0x03, 0x02, 0x07, 0x80}, // digitalSignature
+			},
+		},
+	}
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, priv)
+	if err != nil {
+		t.Fatalf("failed to create CSR: %v", err)
+	}
+
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		t.Fatalf("failed to parse CSR: %v", err)
+	}
+
+	if err := ValidateCSRExtentions(csr, []string{"spiffe://cluster.local/ns/default/sa/a"}, DefaultSANPolicy()); err != nil {
+		t.Errorf("expected a critical keyUsage extension to be allowed by the default policy, got error: %v", err)
+	}
+}
@@ -0,0 +1,35 @@
+/*
+Copyright 2021 The cert-manager Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package signer declares the interface istio-csr uses to turn an
+// authenticated, authorized CSR into a signed certificate chain. The Vault
+// backend in pkg/signer/vault implements Signer; the cert-manager signing
+// path does not implement it.
+package signer
+
+import (
+	"context"
+	"time"
+)
+
+// Signer takes a PEM encoded CSR that has already passed authRequest, and
+// returns the signed leaf certificate and the remainder of the trust chain,
+// both PEM encoded.
+type Signer interface {
+	// Sign signs csrPEM on behalf of identities for the given duration,
+	// returning the leaf certificate and the chain behind it.
+	Sign(ctx context.Context, identities string, csrPEM []byte, duration time.Duration) (certPEM []byte, chainPEM []byte, err error)
+}
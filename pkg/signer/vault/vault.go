@@ -0,0 +1,219 @@
+/*
+Copyright 2021 The cert-manager Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package vault implements signer.Signer against HashiCorp Vault's PKI
+// secrets engine, modelled on Istio's own Vault CA integration: the
+// ServiceAccount token is exchanged for a Vault token via the Kubernetes
+// auth method, then used to sign CSRs through the configured PKI role.
+package vault
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	vaultapi "github.com/hashicorp/vault/api"
+
+	"github.com/cert-manager/istio-csr/pkg/signer"
+)
+
+// defaultServiceAccountTokenPath is where the projected Kubernetes
+// ServiceAccount token used to authenticate to Vault is mounted by default.
+const defaultServiceAccountTokenPath = "/var/run/secrets/tokens/istio-csr-vault-token"
+
+// tokenRenewalBuffer is how far ahead of the Vault token's lease expiry the
+// Signer re-authenticates, so an in-flight Sign call never races the
+// token's actual expiry.
+const tokenRenewalBuffer = 30 * time.Second
+
+// TLSConfig configures TLS when dialling Vault.
+type TLSConfig struct {
+	// CAFile is a PEM bundle used to verify Vault's serving certificate,
+	// in place of the system trust store.
+	CAFile string
+	// SkipVerify disables verification of Vault's serving certificate.
+	// Only intended for development.
+	SkipVerify bool
+}
+
+// Config configures a Signer backed by Vault's PKI secrets engine.
+type Config struct {
+	// Address is the base URL of the Vault server, e.g. "https://vault:8200".
+	Address string
+	// AuthPath is the mount path of the Kubernetes auth method used to
+	// exchange the local ServiceAccount token for a Vault token, e.g.
+	// "auth/kubernetes".
+	AuthPath string
+	// Role is the Vault Kubernetes auth role to authenticate as.
+	Role string
+	// SignCSRPath is the PKI secrets engine path used to sign CSRs, e.g.
+	// "pki/sign/istio-csr".
+	SignCSRPath string
+	// ServiceAccountTokenPath overrides defaultServiceAccountTokenPath.
+	ServiceAccountTokenPath string
+	TLS                     TLSConfig
+}
+
+// Signer signs CSRs against Vault's PKI secrets engine.
+type Signer struct {
+	cfg    Config
+	client *vaultapi.Client
+
+	// mu guards reauthentication: Sign calls may run concurrently, but
+	// only one of them should re-authenticate when the Vault token nears
+	// expiry.
+	mu          sync.Mutex
+	tokenExpiry time.Time
+}
+
+var _ signer.Signer = &Signer{}
+
+// New builds a Vault-backed Signer and performs the initial Kubernetes auth
+// login against Vault.
+func New(ctx context.Context, cfg Config) (*Signer, error) {
+	vaultCfg := vaultapi.DefaultConfig()
+	vaultCfg.Address = cfg.Address
+
+	if cfg.TLS.CAFile != "" || cfg.TLS.SkipVerify {
+		tlsCfg := &vaultapi.TLSConfig{CACert: cfg.TLS.CAFile, Insecure: cfg.TLS.SkipVerify}
+		if err := vaultCfg.ConfigureTLS(tlsCfg); err != nil {
+			return nil, fmt.Errorf("failed to configure vault client TLS: %w", err)
+		}
+	}
+
+	client, err := vaultapi.NewClient(vaultCfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build vault client: %w", err)
+	}
+
+	s := &Signer{cfg: cfg, client: client}
+	if err := s.ensureAuthenticated(ctx); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// authenticate exchanges the local projected ServiceAccount token for a
+// Vault token using the Kubernetes auth method, sets it on the client, and
+// records its lease expiry so ensureAuthenticated knows when to renew.
+// Callers must hold s.mu.
+func (s *Signer) authenticate(ctx context.Context) error {
+	tokenPath := s.cfg.ServiceAccountTokenPath
+	if tokenPath == "" {
+		tokenPath = defaultServiceAccountTokenPath
+	}
+
+	jwt, err := os.ReadFile(tokenPath)
+	if err != nil {
+		return fmt.Errorf("failed to read service account token for vault auth: %w", err)
+	}
+
+	secret, err := s.client.Logical().WriteWithContext(ctx, loginPath(s.cfg.AuthPath), map[string]interface{}{
+		"jwt":  strings.TrimSpace(string(jwt)),
+		"role": s.cfg.Role,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to authenticate to vault: %w", err)
+	}
+	if secret == nil || secret.Auth == nil || secret.Auth.ClientToken == "" {
+		return fmt.Errorf("vault kubernetes auth login returned no client token")
+	}
+
+	s.client.SetToken(secret.Auth.ClientToken)
+	s.tokenExpiry = time.Now().Add(time.Duration(secret.Auth.LeaseDuration) * time.Second)
+	return nil
+}
+
+// ensureAuthenticated re-authenticates against Vault if the current token
+// is at or near its lease expiry. Without this, a Signer that runs
+// long-lived in the signing path would fail every Sign call once its
+// initial login lease ran out.
+func (s *Signer) ensureAuthenticated(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if time.Now().Before(s.tokenExpiry.Add(-tokenRenewalBuffer)) {
+		return nil
+	}
+
+	return s.authenticate(ctx)
+}
+
+func loginPath(authPath string) string {
+	return strings.TrimSuffix(authPath, "/") + "/login"
+}
+
+// Sign implements signer.Signer by POSTing csrPEM to the configured PKI
+// sign path and parsing the returned certificate and CA chain.
+func (s *Signer) Sign(ctx context.Context, identities string, csrPEM []byte, duration time.Duration) ([]byte, []byte, error) {
+	if err := s.ensureAuthenticated(ctx); err != nil {
+		return nil, nil, fmt.Errorf("failed to refresh vault authentication: %w", err)
+	}
+
+	data := map[string]interface{}{
+		"csr":         string(csrPEM),
+		"common_name": identities,
+		"ttl":         duration.String(),
+	}
+
+	secret, err := s.client.Logical().WriteWithContext(ctx, s.cfg.SignCSRPath, data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to sign csr via vault: %w", err)
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, nil, fmt.Errorf("vault returned no data for sign request")
+	}
+
+	certPEM, ok := secret.Data["certificate"].(string)
+	if !ok || certPEM == "" {
+		return nil, nil, fmt.Errorf("vault sign response missing certificate")
+	}
+
+	var chain bytes.Buffer
+	if caChain, ok := secret.Data["ca_chain"].([]interface{}); ok {
+		for _, ca := range caChain {
+			caPEM, ok := ca.(string)
+			if !ok {
+				continue
+			}
+			chain.WriteString(caPEM)
+			chain.WriteString("\n")
+		}
+	} else if issuingCA, ok := secret.Data["issuing_ca"].(string); ok {
+		chain.WriteString(issuingCA)
+	}
+
+	if _, err := parsePEMCertificate([]byte(certPEM)); err != nil {
+		return nil, nil, fmt.Errorf("vault returned an invalid certificate: %w", err)
+	}
+
+	return []byte(certPEM), chain.Bytes(), nil
+}
+
+func parsePEMCertificate(pemBytes []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
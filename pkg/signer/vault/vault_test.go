@@ -0,0 +1,243 @@
+/*
+Copyright 2021 The cert-manager Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vault
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLoginPath(t *testing.T) {
+	tests := map[string]struct {
+		authPath string
+		want     string
+	}{
+		"no trailing slash": {authPath: "auth/kubernetes", want: "auth/kubernetes/login"},
+		"trailing slash":    {authPath: "auth/kubernetes/", want: "auth/kubernetes/login"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := loginPath(tc.authPath); got != tc.want {
+				t.Errorf("loginPath(%q) = %q, want %q", tc.authPath, got, tc.want)
+			}
+		})
+	}
+}
+
+func mustSelfSignedCertPEM(t *testing.T) string {
+	t.Helper()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{SerialNumber: big.NewInt(1)}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, pub, priv)
+	if err != nil {
+		t.Fatalf("failed to create test certificate: %v", err)
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+// writeServiceAccountToken writes a fake JWT to a file in a temporary
+// directory, returning
+// its path, for use as Config.ServiceAccountTokenPath in tests.
+func writeServiceAccountToken(t *testing.T) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("fake-jwt"), 0o600); err != nil {
+		t.Fatalf("failed to write fake service account token: %v", err)
+	}
+	return path
+}
+
+// vaultServer is a minimal fake of the Vault HTTP API endpoints the Signer
+// uses: Kubernetes auth login and PKI sign.
+type vaultServer struct {
+	*httptest.Server
+	loginCalls int32
+	leaseSecs  int
+	signData   map[string]interface{}
+	signErr    bool
+}
+
+func newVaultServer(t *testing.T, leaseSecs int, signData map[string]interface{}, signErr bool) *vaultServer {
+	t.Helper()
+
+	vs := &vaultServer{leaseSecs: leaseSecs, signData: signData, signErr: signErr}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/auth/kubernetes/login", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&vs.loginCalls, 1)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"auth": map[string]interface{}{
+				"client_token":   "fake-vault-token",
+				"lease_duration": vs.leaseSecs,
+			},
+		})
+	})
+	mux.HandleFunc("/v1/pki/sign/istio-csr", func(w http.ResponseWriter, r *http.Request) {
+		if vs.signErr {
+			w.WriteHeader(http.StatusInternalServerError)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{"boom"}})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": vs.signData})
+	})
+	vs.Server = httptest.NewServer(mux)
+	return vs
+}
+
+func newTestSigner(t *testing.T, vs *vaultServer) *Signer {
+	t.Helper()
+
+	ctx := context.Background()
+	s, err := New(ctx, Config{
+		Address:                 vs.URL,
+		AuthPath:                "auth/kubernetes",
+		Role:                    "istio-csr",
+		SignCSRPath:             "pki/sign/istio-csr",
+		ServiceAccountTokenPath: writeServiceAccountToken(t),
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return s
+}
+
+func TestSigner_Sign(t *testing.T) {
+	certPEM := mustSelfSignedCertPEM(t)
+	chainPEM := mustSelfSignedCertPEM(t)
+
+	tests := map[string]struct {
+		signData map[string]interface{}
+		signErr  bool
+		wantErr  bool
+	}{
+		"ca_chain is used when present": {
+			signData: map[string]interface{}{
+				"certificate": certPEM,
+				"ca_chain":    []interface{}{chainPEM},
+			},
+		},
+		"issuing_ca is used when ca_chain is absent": {
+			signData: map[string]interface{}{
+				"certificate": certPEM,
+				"issuing_ca":  chainPEM,
+			},
+		},
+		"missing certificate is an error": {
+			signData: map[string]interface{}{
+				"issuing_ca": chainPEM,
+			},
+			wantErr: true,
+		},
+		"invalid certificate is an error": {
+			signData: map[string]interface{}{
+				"certificate": "not a cert",
+			},
+			wantErr: true,
+		},
+		"vault error response is propagated": {
+			signErr: true,
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			vs := newVaultServer(t, 3600, tc.signData, tc.signErr)
+			defer vs.Close()
+
+			s := newTestSigner(t, vs)
+
+			gotCert, gotChain, err := s.Sign(context.Background(), "spiffe://cluster.local/ns/default/sa/a", []byte("fake-csr"), time.Hour)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Sign() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+
+			if string(gotCert) != certPEM {
+				t.Errorf("Sign() cert = %q, want %q", gotCert, certPEM)
+			}
+			if len(gotChain) == 0 {
+				t.Errorf("Sign() chain is empty, want chain derived from %v", tc.signData)
+			}
+		})
+	}
+}
+
+func TestSigner_Sign_ReauthenticatesOnExpiry(t *testing.T) {
+	certPEM := mustSelfSignedCertPEM(t)
+	vs := newVaultServer(t, 3600, map[string]interface{}{"certificate": certPEM}, false)
+	defer vs.Close()
+
+	s := newTestSigner(t, vs)
+
+	if calls := atomic.LoadInt32(&vs.loginCalls); calls != 1 {
+		t.Fatalf("expected 1 login call after New(), got %d", calls)
+	}
+
+	// Force the cached token to look expired.
+	s.mu.Lock()
+	s.tokenExpiry = time.Now().Add(-time.Hour)
+	s.mu.Unlock()
+
+	if _, _, err := s.Sign(context.Background(), "spiffe://cluster.local/ns/default/sa/a", []byte("fake-csr"), time.Hour); err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+
+	if calls := atomic.LoadInt32(&vs.loginCalls); calls != 2 {
+		t.Errorf("expected Sign() to re-authenticate once the token looked expired, got %d login calls", calls)
+	}
+}
+
+func TestSigner_Sign_DoesNotReauthenticateWhileTokenIsFresh(t *testing.T) {
+	certPEM := mustSelfSignedCertPEM(t)
+	vs := newVaultServer(t, 3600, map[string]interface{}{"certificate": certPEM}, false)
+	defer vs.Close()
+
+	s := newTestSigner(t, vs)
+
+	for i := 0; i < 3; i++ {
+		if _, _, err := s.Sign(context.Background(), "spiffe://cluster.local/ns/default/sa/a", []byte("fake-csr"), time.Hour); err != nil {
+			t.Fatalf("Sign() error = %v", err)
+		}
+	}
+
+	if calls := atomic.LoadInt32(&vs.loginCalls); calls != 1 {
+		t.Errorf("expected no re-authentication while the token is fresh, got %d login calls", calls)
+	}
+}